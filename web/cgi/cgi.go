@@ -0,0 +1,140 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package cgi adapts a web.Handler to the classic (non-Fast) CGI protocol:
+// one request per process invocation, read from os.Environ and os.Stdin.
+package cgi
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/intel352/twister/web"
+)
+
+// Serve reads the single CGI request described by the process environment
+// and os.Stdin, runs h, and writes the response to os.Stdout. It's meant
+// to be the entire body of a program's main function.
+func Serve(h web.Handler) os.Error {
+	env := environMap()
+
+	req := &web.Request{
+		Method:      env["REQUEST_METHOD"],
+		ContentType: env["CONTENT_TYPE"],
+		RemoteAddr:  env["REMOTE_ADDR"],
+		Header:      web.NewStringsMap(),
+		Param:       web.NewStringsMap(),
+		Cookie:      web.NewStringsMap(),
+		Body:        os.Stdin,
+	}
+	if cl, err := strconv.Atoi(env["CONTENT_LENGTH"]); err == nil {
+		req.ContentLength = cl
+	} else {
+		req.ContentLength = -1
+	}
+	for name, value := range env {
+		if len(name) > 5 && name[:5] == "HTTP_" {
+			req.Header.Set(cgiToHeaderName(name[5:]), value)
+		}
+	}
+	if v, found := req.Header.Get(web.HeaderCookie); found {
+		for name, values := range web.ParseCookieHeader(v) {
+			for _, value := range values {
+				req.Cookie.Append(name, value)
+			}
+		}
+	}
+
+	requestURI := env["REQUEST_URI"]
+	if requestURI == "" {
+		requestURI = env["SCRIPT_NAME"] + env["PATH_INFO"]
+		if q := env["QUERY_STRING"]; q != "" {
+			requestURI += "?" + q
+		}
+	}
+	if u, err := web.ParseURL(requestURI); err == nil {
+		req.URL = u
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	req.Responder = &cgiResponder{w: w}
+
+	h.ServeWeb(req)
+
+	return w.Flush()
+}
+
+func environMap() map[string]string {
+	m := make(map[string]string)
+	for _, kv := range os.Environ() {
+		for i := 0; i < len(kv); i++ {
+			if kv[i] == '=' {
+				m[kv[:i]] = kv[i+1:]
+				break
+			}
+		}
+	}
+	return m
+}
+
+// cgiResponder writes a CGI-style status line and headers to w.
+type cgiResponder struct {
+	w *bufio.Writer
+}
+
+func (cr *cgiResponder) Respond(status int, header web.StringsMap) web.ResponseBody {
+	fmt.Fprintf(cr.w, "Status: %d %s\r\n", status, web.StatusText(status))
+	for name, values := range header {
+		for _, value := range values {
+			fmt.Fprintf(cr.w, "%s: %s\r\n", name, value)
+		}
+	}
+	cr.w.WriteString("\r\n")
+	return cgiResponseBody{cr.w}
+}
+
+type cgiResponseBody struct {
+	w *bufio.Writer
+}
+
+func (rb cgiResponseBody) Write(p []byte) (int, os.Error) {
+	return rb.w.Write(p)
+}
+
+func (rb cgiResponseBody) Flush() os.Error {
+	return rb.w.Flush()
+}
+
+// cgiToHeaderName converts an HTTP_FOO_BAR CGI environment variable suffix
+// to the canonical Foo-Bar header name.
+func cgiToHeaderName(s string) string {
+	b := []byte(s)
+	upper := true
+	for i, c := range b {
+		switch {
+		case c == '_':
+			b[i] = '-'
+			upper = true
+		case upper:
+			upper = false
+		default:
+			if c >= 'A' && c <= 'Z' {
+				b[i] = c - 'A' + 'a'
+			}
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,177 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"os"
+	"strings"
+)
+
+// compressSkipContentTypePrefixes lists content types that are already
+// compressed (or compress poorly) and so are never re-encoded.
+var compressSkipContentTypePrefixes = []string{
+	"image/",
+	"video/",
+	"application/zip",
+	"application/gzip",
+}
+
+// Compress returns a handler that transparently gzip- or deflate-encodes
+// responses from handler when the client's Accept-Encoding header allows
+// it. Responses smaller than minSize, and responses whose Content-Type
+// looks already compressed, are left untouched. It slots next to
+// DebugLogger as another response-shaping filter, but replaces the
+// Responder outright rather than using FilterRespond, since it needs to
+// wrap the ResponseBody, not just the status and header.
+func Compress(minSize int, handler Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		if encoding := acceptedEncoding(req); encoding != "" {
+			req.Responder = &compressResponder{req.Responder, encoding, minSize}
+		}
+		handler.ServeWeb(req)
+	})
+}
+
+// acceptedEncoding returns "gzip", "deflate" or "" depending on what req's
+// Accept-Encoding header advertises, preferring gzip.
+func acceptedEncoding(req *Request) string {
+	v, found := req.Header.Get(HeaderAcceptEncoding)
+	if !found {
+		return ""
+	}
+	encoding := ""
+	for _, enc := range strings.Split(v, ",") {
+		switch strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			encoding = "deflate"
+		}
+	}
+	return encoding
+}
+
+func isCompressedContentType(header StringsMap) bool {
+	ct, found := header.Get(HeaderContentType)
+	if !found {
+		return false
+	}
+	for _, prefix := range compressSkipContentTypePrefixes {
+		if strings.HasPrefix(ct, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressResponder wraps a Responder, deferring the decision of whether
+// to compress until enough of the body has been written to compare
+// against minSize.
+type compressResponder struct {
+	Responder
+	encoding string
+	minSize  int
+}
+
+func (cr *compressResponder) Respond(status int, header StringsMap) ResponseBody {
+	if isCompressedContentType(header) {
+		return cr.Responder.Respond(status, header)
+	}
+	return &compressWriter{
+		responder: cr.Responder,
+		status:    status,
+		header:    header,
+		encoding:  cr.encoding,
+		minSize:   cr.minSize,
+	}
+}
+
+// compressWriter buffers the first bytes written to a response until
+// either minSize bytes have been seen, at which point it calls through to
+// the wrapped Responder with Content-Encoding set and starts compressing,
+// or the handler finishes without reaching minSize, at which point the
+// buffered bytes are written uncompressed on Flush.
+type compressWriter struct {
+	responder Responder
+	status    int
+	header    StringsMap
+	encoding  string
+	minSize   int
+
+	body    ResponseBody
+	buf     []byte
+	encoder interface {
+		Write([]byte) (int, os.Error)
+		Close() os.Error
+	}
+}
+
+func (cw *compressWriter) startEncoding() os.Error {
+	cw.header.Set(HeaderContentEncoding, cw.encoding)
+	cw.header.Append(HeaderVary, HeaderAcceptEncoding)
+	cw.header.Del(HeaderContentLength)
+	cw.body = cw.responder.Respond(cw.status, cw.header)
+
+	switch cw.encoding {
+	case "gzip":
+		cw.encoder = gzip.NewWriter(cw.body)
+	case "deflate":
+		w, err := flate.NewWriter(cw.body, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		cw.encoder = w
+	}
+	_, err := cw.encoder.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) Write(p []byte) (int, os.Error) {
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < cw.minSize {
+		return len(p), nil
+	}
+	if err := cw.startEncoding(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush finalizes the response: if enough bytes were written to trigger
+// compression it closes the encoder (emitting any trailer), otherwise it
+// writes the buffered bytes through uncompressed.
+func (cw *compressWriter) Flush() os.Error {
+	if cw.encoder != nil {
+		if err := cw.encoder.Close(); err != nil {
+			return err
+		}
+		return cw.body.Flush()
+	}
+	cw.body = cw.responder.Respond(cw.status, cw.header)
+	if len(cw.buf) > 0 {
+		if _, err := cw.body.Write(cw.buf); err != nil {
+			return err
+		}
+		cw.buf = nil
+	}
+	return cw.body.Flush()
+}
@@ -0,0 +1,136 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Cookie represents an HTTP cookie as sent in a Set-Cookie response header.
+//
+// The zero value is a session cookie (no Expires or Max-Age) scoped to the
+// root path.
+type Cookie struct {
+	Name     string
+	Value    string
+	Path     string
+	Domain   string
+	Expires  int64 // seconds since the Unix epoch, 0 means omit the attribute
+	MaxAge   int64 // seconds, <= 0 means omit the attribute
+	Secure   bool
+	HttpOnly bool
+	SameSite string // "", "Lax", "Strict" or "None"
+}
+
+// String formats the cookie as an RFC 6265 Set-Cookie attribute list.
+func (c *Cookie) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s=%s", c.Name, quoteCookieValue(c.Value))
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+	fmt.Fprintf(&b, "; Path=%s", path)
+	if c.Domain != "" {
+		fmt.Fprintf(&b, "; Domain=%s", c.Domain)
+	}
+	if c.Expires != 0 {
+		fmt.Fprintf(&b, "; Expires=%s", time.Unix(c.Expires, 0).UTC().Format(time.RFC1123))
+	}
+	if c.MaxAge > 0 {
+		fmt.Fprintf(&b, "; Max-Age=%d", c.MaxAge)
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != "" {
+		fmt.Fprintf(&b, "; SameSite=%s", c.SameSite)
+	}
+	return b.String()
+}
+
+// quoteCookieValue wraps value in double quotes, as permitted by RFC 6265,
+// when it contains characters that would otherwise terminate the
+// cookie-pair early.
+func quoteCookieValue(value string) string {
+	if strings.IndexAny(value, " ,;\"") < 0 {
+		return value
+	}
+	return "\"" + strings.Replace(value, "\"", "", -1) + "\""
+}
+
+// SetCookie adds c to the response via a Set-Cookie header, using
+// FilterRespond so the header survives any Responder already installed on
+// req (for example DebugLogger's response logger).
+func SetCookie(req *Request, c *Cookie) {
+	s := c.String()
+	FilterRespond(req, func(status int, header StringsMap) (int, StringsMap) {
+		header.Append(HeaderSetCookie, s)
+		return status, header
+	})
+}
+
+// ParseCookieHeader parses the value of a Cookie request header and returns
+// the name/value pairs it contains. Quoted values are unquoted and a
+// leading dot on a name is not treated specially; it's present here only to
+// mirror domain matching rules used when cookies are sent, per RFC 6265
+// section 5.4. A name may legally appear more than once (the client sent
+// cookies with the same name but different paths or domains), so values
+// are appended rather than overwriting one another.
+func ParseCookieHeader(header string) StringsMap {
+	m := NewStringsMap()
+	for _, part := range strings.Split(header, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(part[:i])
+		value := strings.TrimSpace(part[i+1:])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if name == "" {
+			continue
+		}
+		m.Append(name, value)
+	}
+	return m
+}
+
+// populateRequestCookies parses the Cookie header on req and appends each
+// name/value pair it contains to req.Cookie.
+func populateRequestCookies(req *Request) os.Error {
+	value, found := req.Header.Get(HeaderCookie)
+	if !found {
+		return nil
+	}
+	for name, values := range ParseCookieHeader(value) {
+		for _, v := range values {
+			req.Cookie.Append(name, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package fcgi adapts a web.Handler to the FastCGI protocol, so twister
+// applications can run behind a gateway such as nginx or Apache.
+package fcgi
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Record types, see the FastCGI specification section 8.
+const (
+	typeBeginRequest    = 1
+	typeAbortRequest    = 2
+	typeEndRequest      = 3
+	typeParams          = 4
+	typeStdin           = 5
+	typeStdout          = 6
+	typeStderr          = 7
+	typeData            = 8
+	typeGetValues       = 9
+	typeGetValuesResult = 10
+	typeUnknownType     = 11
+)
+
+// Roles, see the FastCGI specification section 8.2.
+const (
+	roleResponder = 1
+	roleAuthorizer = 2
+	roleFilter     = 3
+)
+
+// Protocol statuses for the body of an END_REQUEST record.
+const (
+	statusRequestComplete = 0
+	statusCantMultiplex   = 1
+	statusOverloaded      = 2
+	statusUnknownRole     = 3
+)
+
+const (
+	maxContentLength = 65535
+	headerLen        = 8
+)
+
+// header is the 8 byte FastCGI record header.
+type header struct {
+	Version       uint8
+	Type          uint8
+	RequestId     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func readHeader(r io.Reader) (header, os.Error) {
+	var buf [headerLen]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return header{}, err
+	}
+	return header{
+		Version:       buf[0],
+		Type:          buf[1],
+		RequestId:     binary.BigEndian.Uint16(buf[2:4]),
+		ContentLength: binary.BigEndian.Uint16(buf[4:6]),
+		PaddingLength: buf[6],
+		Reserved:      buf[7],
+	}, nil
+}
+
+func writeRecord(w io.Writer, recType uint8, requestId uint16, content []byte) os.Error {
+	for len(content) > 0 || recType == typeStdout || recType == typeStderr {
+		n := len(content)
+		if n > maxContentLength {
+			n = maxContentLength
+		}
+		chunk := content[:n]
+		content = content[n:]
+
+		pad := (8 - (len(chunk) % 8)) % 8
+		var buf [headerLen]byte
+		buf[0] = 1 // FCGI_VERSION_1
+		buf[1] = recType
+		binary.BigEndian.PutUint16(buf[2:4], requestId)
+		binary.BigEndian.PutUint16(buf[4:6], uint16(len(chunk)))
+		buf[6] = uint8(pad)
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+		if pad > 0 {
+			var padding [8]byte
+			if _, err := w.Write(padding[:pad]); err != nil {
+				return err
+			}
+		}
+		if len(content) == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func writeEndRequest(w io.Writer, requestId uint16, appStatus uint32, protocolStatus uint8) os.Error {
+	var body [8]byte
+	binary.BigEndian.PutUint32(body[0:4], appStatus)
+	body[4] = protocolStatus
+	return writeRecord(w, typeEndRequest, requestId, body[:])
+}
+
+// readNameValuePairs decodes the PARAMS/DATA name-value pair encoding
+// described in FastCGI specification section 8.3.
+func readNameValuePairs(b []byte) map[string]string {
+	m := make(map[string]string)
+	for len(b) > 0 {
+		nameLen, n := readLength(b)
+		b = b[n:]
+		valueLen, n := readLength(b)
+		b = b[n:]
+		if int(nameLen)+int(valueLen) > len(b) {
+			break
+		}
+		name := string(b[:nameLen])
+		b = b[nameLen:]
+		value := string(b[:valueLen])
+		b = b[valueLen:]
+		m[name] = value
+	}
+	return m
+}
+
+func readLength(b []byte) (uint32, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	if b[0]&0x80 == 0 {
+		return uint32(b[0]), 1
+	}
+	if len(b) < 4 {
+		return 0, len(b)
+	}
+	return binary.BigEndian.Uint32(b[:4]) & 0x7fffffff, 4
+}
@@ -0,0 +1,258 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package fcgi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/intel352/twister/web"
+)
+
+// Serve accepts connections on l, speaking the FastCGI protocol on each and
+// dispatching requests to h. It returns the first error from l.Accept,
+// temporary or not; typically that is because l was closed.
+func Serve(l net.Listener, h web.Handler) os.Error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConn(c, h)
+	}
+	panic("unreachable")
+}
+
+// serveConn handles every record on c until EOF, demultiplexing concurrent
+// requests by their FastCGI request id into one goroutine each.
+func serveConn(c net.Conn, h web.Handler) {
+	defer c.Close()
+
+	var mu sync.Mutex
+	reqs := make(map[uint16]*fcgiRequest)
+
+	for {
+		hdr, err := readHeader(c)
+		if err != nil {
+			return
+		}
+		content := make([]byte, hdr.ContentLength)
+		if _, err := io.ReadFull(c, content); err != nil {
+			return
+		}
+		if hdr.PaddingLength > 0 {
+			padding := make([]byte, hdr.PaddingLength)
+			if _, err := io.ReadFull(c, padding); err != nil {
+				return
+			}
+		}
+
+		mu.Lock()
+		req := reqs[hdr.RequestId]
+		mu.Unlock()
+
+		switch hdr.Type {
+		case typeBeginRequest:
+			req = newFcgiRequest(c, hdr.RequestId)
+			mu.Lock()
+			reqs[hdr.RequestId] = req
+			mu.Unlock()
+
+		case typeParams:
+			if req == nil {
+				continue
+			}
+			if len(content) == 0 {
+				req.paramsDone()
+				continue
+			}
+			req.addParams(content)
+
+		case typeStdin:
+			if req == nil {
+				continue
+			}
+			if len(content) == 0 {
+				req.stdinDone()
+				// Remove req from reqs now, before handing it off, so a
+				// stray PARAMS/STDIN record for this request id (a
+				// duplicate, or an id reused too early by a misbehaving
+				// gateway) is dropped by the req == nil checks above
+				// instead of racing with req.serve reading req.params and
+				// req.stdin concurrently.
+				mu.Lock()
+				delete(reqs, hdr.RequestId)
+				mu.Unlock()
+				go req.serve(h)
+				continue
+			}
+			req.stdin.Write(content)
+
+		case typeAbortRequest:
+			if req != nil {
+				req.abort()
+			}
+			mu.Lock()
+			delete(reqs, hdr.RequestId)
+			mu.Unlock()
+		}
+	}
+}
+
+// fcgiRequest accumulates the PARAMS and STDIN records for one FastCGI
+// request id until both are complete, at which point serve dispatches it
+// to the web.Handler.
+type fcgiRequest struct {
+	conn      net.Conn
+	requestId uint16
+	params    map[string]string
+	paramBuf  bytes.Buffer
+	stdin     bytes.Buffer
+	aborted   bool
+}
+
+func newFcgiRequest(conn net.Conn, requestId uint16) *fcgiRequest {
+	return &fcgiRequest{conn: conn, requestId: requestId, params: make(map[string]string)}
+}
+
+func (r *fcgiRequest) addParams(b []byte) {
+	r.paramBuf.Write(b)
+}
+
+func (r *fcgiRequest) paramsDone() {
+	for k, v := range readNameValuePairs(r.paramBuf.Bytes()) {
+		r.params[k] = v
+	}
+}
+
+func (r *fcgiRequest) stdinDone() {}
+
+func (r *fcgiRequest) abort() { r.aborted = true }
+
+// serve builds a *web.Request from the accumulated FastCGI params and
+// stdin body and runs h, streaming the response back as STDOUT records
+// followed by an END_REQUEST record.
+func (r *fcgiRequest) serve(h web.Handler) {
+	if r.aborted {
+		return
+	}
+
+	req := &web.Request{
+		Method:        r.params["REQUEST_METHOD"],
+		ContentType:   r.params["CONTENT_TYPE"],
+		RemoteAddr:    r.params["REMOTE_ADDR"],
+		Header:        web.NewStringsMap(),
+		Param:         web.NewStringsMap(),
+		Cookie:        web.NewStringsMap(),
+		Body:          &r.stdin,
+	}
+	if cl, err := strconv.Atoi(r.params["CONTENT_LENGTH"]); err == nil {
+		req.ContentLength = cl
+	} else {
+		req.ContentLength = -1
+	}
+	for name, value := range r.params {
+		if len(name) > 5 && name[:5] == "HTTP_" {
+			req.Header.Set(cgiToHeaderName(name[5:]), value)
+		}
+	}
+	if v, found := req.Header.Get(web.HeaderCookie); found {
+		for name, values := range web.ParseCookieHeader(v) {
+			for _, value := range values {
+				req.Cookie.Append(name, value)
+			}
+		}
+	}
+	rawQuery := r.params["QUERY_STRING"]
+	requestURI := r.params["REQUEST_URI"]
+	if requestURI == "" {
+		requestURI = r.params["SCRIPT_NAME"] + r.params["PATH_INFO"]
+		if rawQuery != "" {
+			requestURI += "?" + rawQuery
+		}
+	}
+	u, err := web.ParseURL(requestURI)
+	if err == nil {
+		req.URL = u
+	}
+
+	req.Responder = &fcgiResponder{conn: r.conn, requestId: r.requestId}
+
+	h.ServeWeb(req)
+
+	writeEndRequest(r.conn, r.requestId, 0, statusRequestComplete)
+}
+
+// fcgiResponder writes a CGI-style status line and headers to the
+// FastCGI STDOUT stream, as the spec requires for the Responder role.
+type fcgiResponder struct {
+	conn      net.Conn
+	requestId uint16
+}
+
+func (fr *fcgiResponder) Respond(status int, header web.StringsMap) web.ResponseBody {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Status: %d %s\r\n", status, web.StatusText(status))
+	for name, values := range header {
+		for _, value := range values {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	b.WriteString("\r\n")
+	writeRecord(fr.conn, typeStdout, fr.requestId, b.Bytes())
+	return &fcgiResponseBody{conn: fr.conn, requestId: fr.requestId}
+}
+
+type fcgiResponseBody struct {
+	conn      net.Conn
+	requestId uint16
+}
+
+func (rb *fcgiResponseBody) Write(p []byte) (int, os.Error) {
+	if err := writeRecord(rb.conn, typeStdout, rb.requestId, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rb *fcgiResponseBody) Flush() os.Error {
+	return writeRecord(rb.conn, typeStdout, rb.requestId, nil)
+}
+
+// cgiToHeaderName converts an HTTP_FOO_BAR CGI environment variable suffix
+// to the canonical Foo-Bar header name.
+func cgiToHeaderName(s string) string {
+	b := []byte(s)
+	upper := true
+	for i, c := range b {
+		switch {
+		case c == '_':
+			b[i] = '-'
+			upper = true
+		case upper:
+			upper = false
+		default:
+			if c >= 'A' && c <= 'Z' {
+				b[i] = c - 'A' + 'a'
+			}
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,345 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileServer returns a handler that serves files out of the directory
+// tree rooted at root, in the manner of http.FileServer: the request URL
+// path is taken relative to root, directories render an index.html if
+// present or a sorted listing otherwise, and files are served with Range,
+// If-Modified-Since/If-None-Match and Content-Type support via ServeFile.
+func FileServer(root string) Handler {
+	return HandlerFunc(func(req *Request) {
+		p := path.Clean("/" + req.URL.Path)
+		ServeFile(req, filepath.Join(root, filepath.FromSlash(p)))
+	})
+}
+
+// ServeFile serves the contents of the file or directory at path. Response
+// headers are passed to req.Respond (the 304 and 416 responses add theirs
+// with FilterRespond), so other middleware (for example DebugLogger) still
+// observes the response.
+func ServeFile(req *Request, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		req.Error(StatusNotFound, os.NewError("twister: file not found"))
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		req.Error(StatusNotFound, os.NewError("twister: file not found"))
+		return
+	}
+
+	if fi.IsDirectory() {
+		serveDirectory(req, name, fi)
+		return
+	}
+
+	etag := fileETag(fi)
+	modTime := fi.Mtime_ns / 1e9
+
+	if inm, found := req.Header.Get(HeaderIfNoneMatch); found && inm == etag {
+		respondNotModified(req, etag, modTime)
+		return
+	}
+	if ims, found := req.Header.Get(HeaderIfModifiedSince); found {
+		if t, err := time.Parse(time.RFC1123, ims); err == nil && modTime <= t.Seconds() {
+			respondNotModified(req, etag, modTime)
+			return
+		}
+	}
+
+	contentType := contentTypeFor(name, f)
+
+	rangeHeader, hasRange := req.Header.Get(HeaderRange)
+	if !hasRange {
+		serveWholeFile(req, f, fi.Size, contentType, etag, modTime)
+		return
+	}
+
+	ranges, err := parseRanges(rangeHeader, fi.Size)
+	if err != nil {
+		FilterRespond(req, func(status int, header StringsMap) (int, StringsMap) {
+			header.Set(HeaderContentRange, fmt.Sprintf("bytes */%d", fi.Size))
+			return status, header
+		})
+		req.Error(StatusRequestedRangeNotSatisfiable, os.NewError("twister: range not satisfiable"))
+		return
+	}
+
+	if len(ranges) == 1 {
+		serveSingleRange(req, f, ranges[0], fi.Size, contentType, etag, modTime)
+		return
+	}
+	serveMultipleRanges(req, f, ranges, fi.Size, contentType, etag, modTime)
+}
+
+// fileETag computes a strong ETag from the file's size, modification time
+// and (on platforms that expose it) inode number, quoted per RFC 7232.
+func fileETag(fi *os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x-%x\"", fi.Size, fi.Mtime_ns, fi.Ino)
+}
+
+func respondNotModified(req *Request, etag string, modTime int64) {
+	FilterRespond(req, func(status int, header StringsMap) (int, StringsMap) {
+		header.Set(HeaderETag, etag)
+		header.Set(HeaderLastModified, time.Unix(modTime, 0).UTC().Format(time.RFC1123))
+		return StatusNotModified, header
+	})
+	req.Respond(StatusNotModified, NewStringsMap()).Flush()
+}
+
+func contentTypeFor(name string, f *os.File) string {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	var buf [512]byte
+	n, _ := f.ReadAt(buf[0:], 0)
+	return sniffContentType(buf[:n])
+}
+
+// sniffContentType guesses a content type from the first bytes of a file,
+// falling back to application/octet-stream, mirroring the small prefix
+// table used by net/http's DetectContentType.
+func sniffContentType(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return "image/gif"
+	case bytes.HasPrefix(b, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(b, []byte("<!DOCTYPE HTML")), bytes.HasPrefix(b, []byte("<html")):
+		return "text/html"
+	}
+	return "application/octet-stream"
+}
+
+func serveWholeFile(req *Request, f *os.File, size int64, contentType, etag string, modTime int64) {
+	body := req.Respond(StatusOK, stringsMapOf(
+		HeaderContentType, contentType,
+		HeaderContentLength, strconv.Itoa64(size),
+		HeaderAcceptRanges, "bytes",
+		HeaderETag, etag,
+		HeaderLastModified, time.Unix(modTime, 0).UTC().Format(time.RFC1123),
+	))
+	io.Copy(body, f)
+	body.Flush()
+}
+
+type byteRange struct {
+	start, length int64
+}
+
+func serveSingleRange(req *Request, f *os.File, r byteRange, size int64, contentType, etag string, modTime int64) {
+	body := req.Respond(StatusPartialContent, stringsMapOf(
+		HeaderContentType, contentType,
+		HeaderContentLength, strconv.Itoa64(r.length),
+		HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size),
+		HeaderAcceptRanges, "bytes",
+		HeaderETag, etag,
+		HeaderLastModified, time.Unix(modTime, 0).UTC().Format(time.RFC1123),
+	))
+	io.Copy(body, io.NewSectionReader(f, r.start, r.length))
+	body.Flush()
+}
+
+func serveMultipleRanges(req *Request, f *os.File, ranges []byteRange, size int64, contentType, etag string, modTime int64) {
+	boundary := "twister-boundary"
+
+	var partHeaders [][]byte
+	var total int64
+	for _, r := range ranges {
+		h := []byte(fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: bytes %d-%d/%d\r\n\r\n",
+			boundary, contentType, r.start, r.start+r.length-1, size))
+		partHeaders = append(partHeaders, h)
+		total += int64(len(h)) + r.length
+	}
+	footer := []byte(fmt.Sprintf("\r\n--%s--\r\n", boundary))
+	total += int64(len(footer))
+
+	body := req.Respond(StatusPartialContent, stringsMapOf(
+		HeaderContentType, "multipart/byteranges; boundary="+boundary,
+		HeaderContentLength, strconv.Itoa64(total),
+		HeaderETag, etag,
+		HeaderLastModified, time.Unix(modTime, 0).UTC().Format(time.RFC1123),
+	))
+	for i, r := range ranges {
+		body.Write(partHeaders[i])
+		io.Copy(body, io.NewSectionReader(f, r.start, r.length))
+	}
+	body.Write(footer)
+	body.Flush()
+}
+
+// maxRanges bounds the number of specs a single header may contain, so a
+// request like "bytes=0-0,2-2,4-4,..." can't force serveMultipleRanges to
+// build an unbounded number of multipart sections from one small header.
+const maxRanges = 100
+
+// parseRanges parses the value of a Range: bytes=... header against a
+// resource of the given size, per RFC 7233 section 2.1. It returns an
+// error if no requested range is satisfiable.
+func parseRanges(header string, size int64) ([]byteRange, os.Error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, os.NewError("twister: unsupported range unit")
+	}
+	specs := strings.Split(header[len(prefix):], ",")
+	if len(specs) > maxRanges {
+		return nil, os.NewError("twister: too many ranges requested")
+	}
+	var ranges []byteRange
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			continue
+		}
+		startStr, endStr := spec[:i], spec[i+1:]
+
+		var start, end int64
+		if startStr == "" {
+			// Suffix range: last N bytes.
+			n, err := strconv.Atoi64(endStr)
+			if err != nil || n <= 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			start = size - n
+			end = size - 1
+		} else {
+			s, err := strconv.Atoi64(startStr)
+			if err != nil || s >= size {
+				continue
+			}
+			start = s
+			if endStr == "" {
+				end = size - 1
+			} else {
+				e, err := strconv.Atoi64(endStr)
+				if err != nil || e >= size {
+					e = size - 1
+				}
+				end = e
+			}
+		}
+		if start > end {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+	if len(ranges) == 0 {
+		return nil, os.NewError("twister: no satisfiable range")
+	}
+	return ranges, nil
+}
+
+// serveDirectory renders dir/index.html if present, otherwise a sorted
+// HTML listing of the directory's entries.
+func serveDirectory(req *Request, dir string, fi *os.FileInfo) {
+	index := filepath.Join(dir, "index.html")
+	if fh, err := os.Open(index); err == nil {
+		fh.Close()
+		ServeFile(req, index)
+		return
+	}
+
+	names, err := readDirNames(dir)
+	if err != nil {
+		req.Error(StatusForbidden, os.NewError("twister: cannot list directory"))
+		return
+	}
+	sort.SortStrings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head><title>%s</title></head><body>\n<ul>\n", htmlEscape(req.URL.Path))
+	if req.URL.Path != "/" {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, name := range names {
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", htmlEscape(name), htmlEscape(name))
+	}
+	b.WriteString("</ul>\n</body></html>\n")
+
+	body := req.Respond(StatusOK, stringsMapOf(
+		HeaderContentType, "text/html; charset=utf-8",
+		HeaderContentLength, strconv.Itoa(b.Len()),
+	))
+	body.Write(b.Bytes())
+	body.Flush()
+}
+
+func readDirNames(dir string) ([]string, os.Error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		if fi.IsDirectory() {
+			names[i] = fi.Name + "/"
+		} else {
+			names[i] = fi.Name
+		}
+	}
+	return names, nil
+}
+
+func htmlEscape(s string) string {
+	s = strings.Replace(s, "&", "&amp;", -1)
+	s = strings.Replace(s, "<", "&lt;", -1)
+	s = strings.Replace(s, ">", "&gt;", -1)
+	return s
+}
+
+// stringsMapOf builds a StringsMap from alternating key/value pairs,
+// skipping pairs whose value is empty, for the common case of assembling
+// a response header inline.
+func stringsMapOf(kv ...string) StringsMap {
+	m := NewStringsMap()
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == "" {
+			continue
+		}
+		m.Set(kv[i], kv[i+1])
+	}
+	return m
+}
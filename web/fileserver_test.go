@@ -0,0 +1,119 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseRangesSingle(t *testing.T) {
+	ranges, err := parseRanges("bytes=0-99", 1000)
+	if err != nil {
+		t.Fatalf("parseRanges: %v", err)
+	}
+	if len(ranges) != 1 || ranges[0].start != 0 || ranges[0].length != 100 {
+		t.Fatalf("got %v", ranges)
+	}
+}
+
+func TestParseRangesSuffix(t *testing.T) {
+	ranges, err := parseRanges("bytes=-50", 1000)
+	if err != nil {
+		t.Fatalf("parseRanges: %v", err)
+	}
+	if len(ranges) != 1 || ranges[0].start != 950 || ranges[0].length != 50 {
+		t.Fatalf("got %v", ranges)
+	}
+}
+
+func TestParseRangesOpenEnded(t *testing.T) {
+	ranges, err := parseRanges("bytes=900-", 1000)
+	if err != nil {
+		t.Fatalf("parseRanges: %v", err)
+	}
+	if len(ranges) != 1 || ranges[0].start != 900 || ranges[0].length != 100 {
+		t.Fatalf("got %v", ranges)
+	}
+}
+
+func TestParseRangesTooMany(t *testing.T) {
+	specs := make([]string, maxRanges+1)
+	for i := range specs {
+		specs[i] = "0-0"
+	}
+	_, err := parseRanges("bytes="+strings.Join(specs, ","), 1000)
+	if err == nil {
+		t.Fatalf("expected an error for more than %d ranges", maxRanges)
+	}
+}
+
+// stubResponder is a minimal Responder/ResponseBody used to observe what
+// ServeFile writes without going through a real Request pipeline.
+type stubResponder struct {
+	status int
+	header StringsMap
+	body   bytes.Buffer
+}
+
+func (s *stubResponder) Respond(status int, header StringsMap) ResponseBody {
+	s.status = status
+	s.header = header
+	return s
+}
+
+func (s *stubResponder) Write(p []byte) (int, os.Error) { return s.body.Write(p) }
+func (s *stubResponder) Flush() os.Error                { return nil }
+
+func TestServeFileNotModified(t *testing.T) {
+	f, err := ioutil.TempFile("", "twister-fileserver-test-")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("hello world")
+	f.Close()
+
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	etag := fileETag(fi)
+
+	responder := &stubResponder{}
+	req := &Request{
+		Method:    "GET",
+		Header:    NewStringsMap(),
+		Param:     NewStringsMap(),
+		Cookie:    NewStringsMap(),
+		Responder: responder,
+	}
+	req.Header.Set(HeaderIfNoneMatch, etag)
+
+	ServeFile(req, f.Name())
+
+	if responder.status != StatusNotModified {
+		t.Fatalf("status = %d, want %d", responder.status, StatusNotModified)
+	}
+	if got, found := responder.header.Get(HeaderETag); !found || got != etag {
+		t.Fatalf("ETag header = %q, found %v; want %q", got, found, etag)
+	}
+	if responder.body.Len() != 0 {
+		t.Fatalf("expected no body for a 304 response, got %d bytes", responder.body.Len())
+	}
+}
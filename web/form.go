@@ -0,0 +1,257 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"os"
+	"strings"
+)
+
+// ProcessFormConfig configures ProcessFormMultipart.
+type ProcessFormConfig struct {
+	// MaxRequestBodyLen rejects the request outright (before reading any
+	// of the body) when ContentLength exceeds it.
+	MaxRequestBodyLen int64
+
+	// MaxMemory bounds how many bytes of part data, text fields and files
+	// together, are buffered in memory across the whole form. A file part
+	// that would push the total over this limit is spilled to a temp file
+	// instead; a text field that would is rejected with an error.
+	MaxMemory int64
+
+	// TempDir is where overflowing file parts are written. The empty
+	// string uses os.TempDir().
+	TempDir string
+}
+
+// FileHeader describes a single uploaded file part of a multipart/form-data
+// request. Open returns its content, reading from memory or from the
+// spilled temp file as appropriate.
+type FileHeader struct {
+	Filename string
+	Header   StringsMap
+	Size     int64
+
+	content  []byte // nil if spilled to disk
+	tempPath string // "" if held in memory
+}
+
+// Open returns a reader over the file's content. The caller must Close the
+// returned file when done with it.
+func (fh *FileHeader) Open() (multipart.File, os.Error) {
+	if fh.tempPath != "" {
+		f, err := os.Open(fh.tempPath)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return &sliceFile{bytes.NewReader(fh.content)}, nil
+}
+
+// sliceFile adapts a bytes.Reader to the multipart.File interface (adding a
+// no-op Close) for in-memory file parts.
+type sliceFile struct {
+	*bytes.Reader
+}
+
+func (f *sliceFile) Close() os.Error { return nil }
+
+// removeTemp deletes the part's backing temp file, if any. Called once the
+// handler that triggered ProcessFormMultipart returns.
+func (fh *FileHeader) removeTemp() {
+	if fh.tempPath != "" {
+		os.Remove(fh.tempPath)
+	}
+}
+
+// File returns the multipart file part named name, along with its header.
+// It returns an error if the request body was not parsed as a
+// multipart/form-data form, or if no part with that name was uploaded.
+func (req *Request) File(name string) (multipart.File, *FileHeader, os.Error) {
+	fh, ok := req.files[name]
+	if !ok {
+		return nil, nil, os.NewError("twister: no file uploaded as " + name)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, fh, nil
+}
+
+// ProcessFormMultipart is like ProcessForm, but additionally understands
+// multipart/form-data bodies: text parts are merged into req.Param exactly
+// as url-encoded fields would be, and file parts are left for handlers to
+// retrieve with req.File. It checks XSRF tokens using defaultXSRFConfig,
+// exactly as ProcessForm(n, true, handler) does; pass checkXSRF as false to
+// skip that check. Use ProcessFormMultipartXSRF to supply a custom
+// XSRFConfig, mirroring ProcessForm/ProcessFormXSRF.
+func ProcessFormMultipart(config *ProcessFormConfig, checkXSRF bool, handler Handler) Handler {
+	if !checkXSRF {
+		return processFormMultipart(config, nil, handler)
+	}
+	return processFormMultipart(config, &defaultXSRFConfig, handler)
+}
+
+// ProcessFormMultipartXSRF is like ProcessFormMultipart, but checks XSRF
+// tokens using xsrfConfig instead of the package defaults.
+func ProcessFormMultipartXSRF(config *ProcessFormConfig, xsrfConfig *XSRFConfig, handler Handler) Handler {
+	return processFormMultipart(config, xsrfConfig, handler)
+}
+
+func processFormMultipart(config *ProcessFormConfig, xsrfConfig *XSRFConfig, handler Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		if !checkRequestBodyLen(req, config.MaxRequestBodyLen) {
+			return
+		}
+
+		contentType, _ := req.Header.Get(HeaderContentType)
+		mediaType, params, mimeErr := mime.ParseMediaType(contentType)
+
+		if mimeErr != nil || !strings.HasPrefix(mediaType, "multipart/") {
+			if err := req.ParseForm(); err != nil {
+				req.Error(StatusBadRequest, os.NewError("twister: Error reading or parsing form."))
+				return
+			}
+		} else {
+			boundary, ok := params["boundary"]
+			if !ok {
+				req.Error(StatusBadRequest, os.NewError("twister: multipart form missing boundary"))
+				return
+			}
+
+			tempDir := config.TempDir
+			if tempDir == "" {
+				tempDir = os.TempDir()
+			}
+
+			defer func() {
+				for _, fh := range req.files {
+					fh.removeTemp()
+				}
+			}()
+			if err := parseMultipartForm(req, multipart.NewReader(req.Body, boundary), config.MaxMemory, tempDir); err != nil {
+				req.Error(StatusBadRequest, os.NewError("twister: Error reading multipart form: "+err.String()))
+				return
+			}
+		}
+
+		if xsrfConfig != nil && !checkRequestXSRF(req, xsrfConfig) {
+			return
+		}
+
+		handler.ServeWeb(req)
+	})
+}
+
+// parseMultipartForm streams the parts of r into req.Param and req.files,
+// buffering up to maxMemory bytes of field and file content in memory.
+// File parts beyond that are spilled to tempDir; a text field beyond it
+// is an error.
+func parseMultipartForm(req *Request, r *multipart.Reader, maxMemory int64, tempDir string) os.Error {
+	remaining := maxMemory
+	for {
+		part, err := r.NextPart()
+		if err == os.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			var b bytes.Buffer
+			n, err := io.CopyN(&b, part, remaining+1)
+			if err != nil && err != os.EOF {
+				return err
+			}
+			if n > remaining {
+				return os.NewError("twister: multipart field " + name + " exceeds MaxMemory")
+			}
+			remaining -= n
+			req.Param.Append(name, b.String())
+			continue
+		}
+
+		fh, err := readFilePart(part, &remaining, tempDir)
+		if err != nil {
+			return err
+		}
+		if req.files == nil {
+			req.files = make(map[string]*FileHeader)
+		}
+		req.files[name] = fh
+	}
+}
+
+// readFilePart buffers a single file part in memory if it fits within
+// *remaining, decrementing *remaining as it goes, or spills it to a temp
+// file under tempDir otherwise.
+func readFilePart(part *multipart.Part, remaining *int64, tempDir string) (*FileHeader, os.Error) {
+	fh := &FileHeader{
+		Filename: part.FileName(),
+		Header:   StringsMap(part.Header),
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, *remaining+1)
+	if err != nil && err != os.EOF {
+		return nil, err
+	}
+
+	if int64(n) <= *remaining {
+		*remaining -= int64(n)
+		fh.content = buf.Bytes()
+		fh.Size = int64(len(fh.content))
+		return fh, nil
+	}
+
+	f, err := ioutil.TempFile(tempDir, "twister-upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	removeOnError := true
+	defer func() {
+		if removeOnError {
+			os.Remove(f.Name())
+		}
+	}()
+
+	size, err := buf.WriteTo(f)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := io.Copy(f, part)
+	if err != nil {
+		return nil, err
+	}
+	removeOnError = false
+
+	fh.tempPath = f.Name()
+	fh.Size = size + rest
+	return fh, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestParseMultipartForm(t *testing.T) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("name", "short value"); err != nil {
+		t.Fatalf("WriteField: %v", err)
+	}
+	fw, err := w.CreateFormFile("upload", "hello.txt")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	fw.Write(bytes.Repeat([]byte("a"), 20))
+	w.Close()
+
+	req := &Request{Param: NewStringsMap()}
+	if err := parseMultipartForm(req, multipart.NewReader(&buf, w.Boundary()), 1024, ""); err != nil {
+		t.Fatalf("parseMultipartForm: %v", err)
+	}
+
+	if v := req.Param.GetDef("name", ""); v != "short value" {
+		t.Fatalf("Param[name] = %q, want %q", v, "short value")
+	}
+
+	fh, ok := req.files["upload"]
+	if !ok {
+		t.Fatalf("expected an upload file part")
+	}
+	if fh.Size != 20 {
+		t.Fatalf("file Size = %d, want 20", fh.Size)
+	}
+	if fh.tempPath != "" {
+		t.Fatalf("a 20 byte file should stay in memory, got tempPath %q", fh.tempPath)
+	}
+}
+
+func TestParseMultipartFormMaxMemorySpillsFileToDisk(t *testing.T) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	fw, err := w.CreateFormFile("upload", "big.bin")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	fw.Write(bytes.Repeat([]byte("b"), 100))
+	w.Close()
+
+	req := &Request{Param: NewStringsMap()}
+	if err := parseMultipartForm(req, multipart.NewReader(&buf, w.Boundary()), 10, ""); err != nil {
+		t.Fatalf("parseMultipartForm: %v", err)
+	}
+
+	fh := req.files["upload"]
+	if fh == nil {
+		t.Fatalf("expected an upload file part")
+	}
+	defer fh.removeTemp()
+
+	if fh.tempPath == "" {
+		t.Fatalf("a 100 byte file over a 10 byte MaxMemory should spill to disk")
+	}
+	if fh.Size != 100 {
+		t.Fatalf("file Size = %d, want 100", fh.Size)
+	}
+}
+
+func TestParseMultipartFormMaxMemoryRejectsOversizedField(t *testing.T) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("big", strings.Repeat("c", 100)); err != nil {
+		t.Fatalf("WriteField: %v", err)
+	}
+	w.Close()
+
+	req := &Request{Param: NewStringsMap()}
+	err := parseMultipartForm(req, multipart.NewReader(&buf, w.Boundary()), 10, "")
+	if err == nil {
+		t.Fatalf("expected an error for a field exceeding MaxMemory")
+	}
+}
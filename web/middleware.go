@@ -16,8 +16,6 @@ package web
 
 import (
 	"bytes"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
@@ -54,16 +52,26 @@ const (
 )
 
 // ProcessForm returns a handler that checks the request body length, parses
-// url encoded forms and optionaly checks for XRSF.
+// url encoded forms and optionaly checks for XRSF using defaultXSRFConfig.
+// Use ProcessFormXSRF to supply a custom XSRFConfig, for example to set a
+// different TokenTTL or a shared Secret across server instances.
 func ProcessForm(maxRequestBodyLen int, checkXSRF bool, handler Handler) Handler {
+	if !checkXSRF {
+		return processForm(maxRequestBodyLen, nil, handler)
+	}
+	return processForm(maxRequestBodyLen, &defaultXSRFConfig, handler)
+}
+
+// ProcessFormXSRF is like ProcessForm, but checks XSRF tokens using config
+// instead of the package defaults.
+func ProcessFormXSRF(maxRequestBodyLen int, config *XSRFConfig, handler Handler) Handler {
+	return processForm(maxRequestBodyLen, config, handler)
+}
+
+func processForm(maxRequestBodyLen int, xsrfConfig *XSRFConfig, handler Handler) Handler {
 	return HandlerFunc(func(req *Request) {
 
-		if req.ContentLength > maxRequestBodyLen {
-			status := StatusRequestEntityTooLarge
-			if _, found := req.Header.Get(HeaderExpect); found {
-				status = StatusExpectationFailed
-			}
-			req.Error(status, os.NewError("twister: Request entity too large."))
+		if !checkRequestBodyLen(req, int64(maxRequestBodyLen)) {
 			return
 		}
 
@@ -72,38 +80,30 @@ func ProcessForm(maxRequestBodyLen int, checkXSRF bool, handler Handler) Handler
 			return
 		}
 
-		if checkXSRF {
-			const tokenLen = 8
-			token, found := req.Cookie.Get(XSRFCookieName)
-
-			// Create new XSRF token?
-			if !found || len(token) != tokenLen {
-				p := make([]byte, tokenLen/2)
-				_, err := rand.Reader.Read(p)
-				if err != nil {
-					panic("twister: rand read failed")
-				}
-				token = hex.EncodeToString(p)
-				c := fmt.Sprintf("%s=%s; Path=/; HttpOnly", XSRFCookieName, token)
-				FilterRespond(req, func(status int, header StringsMap) (int, StringsMap) {
-					header.Append(HeaderSetCookie, c)
-					return status, header
-				})
-			}
-
-			if token != req.Param.GetDef(XSRFParamName, "") {
-				req.Param.Set(XSRFParamName, token)
-				if req.Method == "POST" || req.Method == "PUT" {
-					req.Error(StatusNotFound, os.NewError("twister: bad xsrf token"))
-					return
-				}
-			}
+		if xsrfConfig != nil && !checkRequestXSRF(req, xsrfConfig) {
+			return
 		}
 
 		handler.ServeWeb(req)
 	})
 }
 
+// checkRequestBodyLen rejects req with 413 (or 417, for clients that sent
+// Expect: 100-continue) when its Content-Length exceeds maxRequestBodyLen.
+// It's shared by ProcessForm and ProcessFormMultipart so the two pipelines
+// apply the same body-size policy.
+func checkRequestBodyLen(req *Request, maxRequestBodyLen int64) bool {
+	if int64(req.ContentLength) > maxRequestBodyLen {
+		status := StatusRequestEntityTooLarge
+		if _, found := req.Header.Get(HeaderExpect); found {
+			status = StatusExpectationFailed
+		}
+		req.Error(status, os.NewError("twister: Request entity too large."))
+		return false
+	}
+	return true
+}
+
 func writeStringMap(w io.Writer, title string, m StringsMap) {
 	first := true
 	for key, values := range m {
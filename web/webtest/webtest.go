@@ -0,0 +1,168 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package webtest provides an in-process harness for exercising twister
+// Handlers without a live socket, mirroring the ergonomics of the stdlib
+// net/http/httptest package.
+package webtest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/intel352/twister/web"
+)
+
+// Recorder is a web.Responder and web.ResponseBody that records the status,
+// header and body written by a Handler for later inspection.
+type Recorder struct {
+	Status int
+	Header web.StringsMap
+	Body   bytes.Buffer
+
+	responded bool
+}
+
+// NewRecorder returns an empty Recorder ready to be assigned to a Request's
+// Responder field.
+func NewRecorder() *Recorder {
+	return &Recorder{Header: web.NewStringsMap()}
+}
+
+// Respond implements web.Responder. It records status and header and
+// returns the Recorder itself as the ResponseBody.
+func (rec *Recorder) Respond(status int, header web.StringsMap) web.ResponseBody {
+	rec.Status = status
+	rec.Header = header
+	rec.responded = true
+	return rec
+}
+
+// Write implements web.ResponseBody, appending to Body.
+func (rec *Recorder) Write(p []byte) (int, os.Error) {
+	return rec.Body.Write(p)
+}
+
+// Flush implements web.ResponseBody. Recorder buffers everything in memory,
+// so Flush is a no-op.
+func (rec *Recorder) Flush() os.Error {
+	return nil
+}
+
+// NewRequest returns a *web.Request for method and url, fully populated so
+// that middleware such as web.ProcessForm and web.DebugLogger can run
+// against it without a live connection. Content-Length is computed for any
+// body that exposes a Len() int method (bytes.Buffer, bytes.Reader and
+// strings.Reader all qualify); for a non-GET/HEAD request with a body, the
+// Content-Type header and the ContentType field are both set to
+// application/x-www-form-urlencoded. The request's
+// Responder is set to a fresh Recorder, returned alongside it for
+// inspection after the handler runs.
+func NewRequest(method, rawurl string, body io.Reader) (*web.Request, *Recorder, os.Error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rc io.ReadCloser
+	if rc2, ok := body.(io.ReadCloser); ok {
+		rc = rc2
+	} else if body != nil {
+		rc = ioutilNopCloser{body}
+	} else {
+		rc = ioutilNopCloser{bytes.NewReader(nil)}
+	}
+
+	contentLength := -1
+	if lr, ok := body.(interface {
+		Len() int
+	}); ok {
+		contentLength = lr.Len()
+	}
+
+	rec := NewRecorder()
+
+	req := &web.Request{
+		Method:          method,
+		URL:             u,
+		ProtocolVersion: 1001,
+		Header:          web.NewStringsMap(),
+		Param:           web.NewStringsMap(),
+		Cookie:          web.NewStringsMap(),
+		ContentLength:   contentLength,
+		RemoteAddr:      "127.0.0.1",
+		Body:            rc,
+		Responder:       rec,
+	}
+
+	if host := u.Host; host != "" {
+		req.Header.Set(web.HeaderHost, host)
+	}
+	if v, found := req.Header.Get(web.HeaderCookie); found {
+		for name, values := range web.ParseCookieHeader(v) {
+			for _, value := range values {
+				req.Cookie.Append(name, value)
+			}
+		}
+	}
+	if contentLength >= 0 {
+		req.Header.Set(web.HeaderContentLength, strconv.Itoa(contentLength))
+	}
+	if body != nil && method != "GET" && method != "HEAD" {
+		if _, found := req.Header.Get(web.HeaderContentType); !found {
+			req.Header.Set(web.HeaderContentType, "application/x-www-form-urlencoded")
+			req.ContentType = "application/x-www-form-urlencoded"
+		}
+	}
+
+	return req, rec, nil
+}
+
+type ioutilNopCloser struct {
+	io.Reader
+}
+
+func (ioutilNopCloser) Close() os.Error { return nil }
+
+// Server is an in-process twister server listening on 127.0.0.1, suitable
+// for tests that want a real TCP round trip (for example to exercise
+// Connection or chunked-encoding behavior) without binding a fixed port.
+type Server struct {
+	URL      string
+	listener net.Listener
+}
+
+// NewServer starts a Server backed by h and returns once it is accepting
+// connections. The caller must call Close when done.
+func NewServer(h web.Handler) (*Server, os.Error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server{
+		URL:      "http://" + l.Addr().String(),
+		listener: l,
+	}
+	go web.Serve(l, h)
+	return srv, nil
+}
+
+// Close shuts down the Server's listener.
+func (s *Server) Close() os.Error {
+	return s.listener.Close()
+}
@@ -0,0 +1,191 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// XSRFConfig configures the HMAC-signed XSRF token checks performed by
+// ProcessForm. Secret should be a long-lived, random value kept outside of
+// source control; rotating it invalidates all outstanding tokens.
+type XSRFConfig struct {
+	Secret     []byte
+	TokenTTL   int64 // max token age in seconds, <= 0 means no expiry check
+	CookieName string
+	ParamName  string
+	HeaderName string
+
+	// SessionIDName is the name of the cookie the application already
+	// sets to identify a logged-in session (for example "sessionid").
+	// When set, XSRF tokens are bound to that cookie's value, so a token
+	// stolen in isolation (without the matching session cookie) can't be
+	// replayed against a different session. Leave it empty for sites that
+	// have no notion of a session; in that case all tokens are bound to
+	// the same (empty) value, equivalent to a single shared server-wide
+	// secret rather than a truly per-session one.
+	SessionIDName string
+}
+
+// defaultXSRFTokenTTL is the token lifetime used by defaultXSRFConfig.
+const defaultXSRFTokenTTL = 24 * 60 * 60 // 1 day, in seconds
+
+// defaultXSRFConfig is used by ProcessForm when no XSRFConfig is supplied,
+// preserving the historical cookie and form field names. Its Secret is
+// generated randomly at process start (see init below): ProcessForm has no
+// way to accept a caller-supplied secret, and signing with an empty key
+// would make tokens forgeable by anyone, which is worse than the random
+// token this feature replaced. It leaves SessionIDName unset, since
+// ProcessForm's callers have no way to tell it what their session cookie
+// is named; applications that want tokens bound to a session should use
+// ProcessFormXSRF with SessionIDName set instead.
+var defaultXSRFConfig = XSRFConfig{
+	CookieName: XSRFCookieName,
+	ParamName:  XSRFParamName,
+	HeaderName: "X-XSRF-Token",
+	TokenTTL:   defaultXSRFTokenTTL,
+}
+
+func init() {
+	defaultXSRFConfig.Secret = make([]byte, 32)
+	if _, err := rand.Reader.Read(defaultXSRFConfig.Secret); err != nil {
+		panic("twister: rand read failed")
+	}
+}
+
+const xsrfNonceLen = 16
+
+// newXSRFToken generates a fresh token for sessionID: a random nonce, the
+// current time and an HMAC-SHA256 of the nonce, sessionID and that time,
+// concatenated and base64 encoded.
+func newXSRFToken(config *XSRFConfig, sessionID string) (string, os.Error) {
+	nonce := make([]byte, xsrfNonceLen)
+	if _, err := rand.Reader.Read(nonce); err != nil {
+		return "", os.NewError("twister: rand read failed")
+	}
+	return signXSRFToken(config, sessionID, nonce, time.Seconds()), nil
+}
+
+// signXSRFToken computes nonce || timestamp || HMAC-SHA256(secret, nonce ||
+// sessionID || timestamp), with timestamp as 8 big-endian bytes, and
+// returns the result base64 encoded.
+func signXSRFToken(config *XSRFConfig, sessionID string, nonce []byte, timestamp int64) string {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp))
+
+	mac := hmac.NewSHA256(config.Secret)
+	mac.Write(nonce)
+	mac.Write([]byte(sessionID))
+	mac.Write(ts[:])
+	sum := mac.Sum()
+
+	buf := make([]byte, 0, len(nonce)+8+len(sum))
+	buf = append(buf, nonce...)
+	buf = append(buf, ts[:]...)
+	buf = append(buf, sum...)
+	return base64.URLEncoding.EncodeToString(buf)
+}
+
+// checkXSRFToken verifies that token was produced by newXSRFToken for
+// sessionID, recomputing the HMAC in constant time and rejecting tokens
+// older than config.TokenTTL.
+func checkXSRFToken(config *XSRFConfig, sessionID string, token string) bool {
+	buf, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	if len(buf) <= xsrfNonceLen+8 {
+		return false
+	}
+	nonce := buf[:xsrfNonceLen]
+	ts := buf[xsrfNonceLen : xsrfNonceLen+8]
+	sum := buf[xsrfNonceLen+8:]
+
+	timestamp := int64(binary.BigEndian.Uint64(ts))
+	if config.TokenTTL > 0 && time.Seconds()-timestamp > config.TokenTTL {
+		return false
+	}
+
+	mac := hmac.NewSHA256(config.Secret)
+	mac.Write(nonce)
+	mac.Write([]byte(sessionID))
+	mac.Write(ts)
+	expected := mac.Sum()
+
+	return subtle.ConstantTimeCompare(sum, expected) == 1
+}
+
+// xsrfTokenParam is the req.Param key under which the request's XSRF token
+// (the valid cookie token, or a freshly minted one) is stashed for
+// retrieval by XSRFToken.
+const xsrfTokenParam = "_xsrf_token"
+
+// XSRFToken returns the XSRF token associated with req, for embedding in a
+// hidden form field or an AJAX request header. ProcessForm must be running
+// ahead of the handler that calls this for a token to be present.
+func XSRFToken(req *Request) string {
+	return req.Param.GetDef(xsrfTokenParam, "")
+}
+
+// checkRequestXSRF validates the XSRF token on req against config, minting
+// and setting a fresh cookie if none is present yet. It returns false if
+// the request was rejected, in which case a response has already been
+// written via req.Error and the caller must not continue.
+func checkRequestXSRF(req *Request, config *XSRFConfig) bool {
+	if len(config.Secret) == 0 {
+		panic("twister: XSRFConfig.Secret must be set")
+	}
+
+	var sessionID string
+	if config.SessionIDName != "" {
+		sessionID, _ = req.Cookie.Get(config.SessionIDName)
+	}
+	token, found := req.Cookie.Get(config.CookieName)
+
+	if !found || !checkXSRFToken(config, sessionID, token) {
+		newToken, err := newXSRFToken(config, sessionID)
+		if err != nil {
+			panic("twister: rand read failed")
+		}
+		token = newToken
+		SetCookie(req, &Cookie{
+			Name:     config.CookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+		})
+	}
+
+	supplied, found := req.Header.Get(config.HeaderName)
+	if !found {
+		supplied = req.Param.GetDef(config.ParamName, "")
+	}
+
+	req.Param.Set(xsrfTokenParam, token)
+
+	if !checkXSRFToken(config, sessionID, supplied) {
+		if req.Method == "POST" || req.Method == "PUT" {
+			req.Error(StatusNotFound, os.NewError("twister: bad xsrf token"))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestXSRFTokenMintAndValidate(t *testing.T) {
+	config := &XSRFConfig{Secret: []byte("test-secret"), TokenTTL: 60}
+
+	token, err := newXSRFToken(config, "session-a")
+	if err != nil {
+		t.Fatalf("newXSRFToken: %v", err)
+	}
+
+	if !checkXSRFToken(config, "session-a", token) {
+		t.Fatalf("token did not validate for the session it was minted for")
+	}
+	if checkXSRFToken(config, "session-b", token) {
+		t.Fatalf("token minted for session-a must not validate for session-b")
+	}
+	if checkXSRFToken(config, "session-a", token+"x") {
+		t.Fatalf("corrupted token must not validate")
+	}
+	if checkXSRFToken(&XSRFConfig{Secret: []byte("other-secret"), TokenTTL: 60}, "session-a", token) {
+		t.Fatalf("token signed with a different secret must not validate")
+	}
+}
+
+func TestXSRFTokenExpiry(t *testing.T) {
+	config := &XSRFConfig{Secret: []byte("test-secret"), TokenTTL: 10}
+
+	fresh := signXSRFToken(config, "session-a", make([]byte, xsrfNonceLen), time.Seconds())
+	if !checkXSRFToken(config, "session-a", fresh) {
+		t.Fatalf("freshly minted token should validate")
+	}
+
+	stale := signXSRFToken(config, "session-a", make([]byte, xsrfNonceLen), time.Seconds()-100)
+	if checkXSRFToken(config, "session-a", stale) {
+		t.Fatalf("token older than TokenTTL should be rejected")
+	}
+}